@@ -0,0 +1,129 @@
+package task
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checksumsDir is where checksum digests are stored, relative to the
+// Taskfile's own directory.
+const checksumsDir = ".task/checksums"
+
+// isTaskUpToDateChecksum reports whether t's digest (see hashSources)
+// still matches the one stored from its last successful run, and every
+// one of its Generates patterns (globs or literal paths, resolved the
+// same way Sources are) still matches at least one existing file.
+func isTaskUpToDateChecksum(name string, t *Task) bool {
+	if len(t.Sources) == 0 {
+		return false
+	}
+
+	digest, err := hashSources(t.Sources)
+	if err != nil {
+		return false
+	}
+
+	stored, err := readChecksum(name)
+	if err != nil || stored != digest {
+		return false
+	}
+
+	for _, p := range t.Generates {
+		matches, err := resolvePattern(p)
+		if err != nil || len(matches) == 0 {
+			return false
+		}
+		for _, m := range matches {
+			if _, err := os.Stat(m); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeChecksum stores t's current Sources digest for name, so a later
+// run can tell via isTaskUpToDateChecksum whether Sources changed since.
+func writeChecksum(name string, t *Task) error {
+	digest, err := hashSources(t.Sources)
+	if err != nil {
+		return err
+	}
+	path := checksumPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(digest), 0o644)
+}
+
+func readChecksum(name string) (string, error) {
+	b, err := ioutil.ReadFile(checksumPath(name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func checksumPath(name string) string {
+	return filepath.Join(filepath.Dir(TaskFilePath), checksumsDir, name)
+}
+
+// hashSources resolves patterns (each either a glob or a literal path, see
+// resolvePattern) to a sorted, de-duplicated file list and streams their
+// contents through a single SHA-256 hash, so the result only depends on
+// file contents, not on mtimes.
+func hashSources(patterns []string) (string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	for _, p := range patterns {
+		matches, err := resolvePattern(p)
+		if err != nil {
+			return "", err
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, f := range files {
+		if err := hashFile(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resolvePattern expands p as a glob; if it matches nothing (e.g. it's a
+// literal path rather than a pattern), p itself is returned as the sole
+// match.
+func resolvePattern(p string) ([]string, error) {
+	matches, err := filepath.Glob(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return []string{p}, nil
+	}
+	return matches, nil
+}
+
+func hashFile(h io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
@@ -0,0 +1,83 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashSourcesIsOrderIndependentAndContentSensitive(t *testing.T) {
+	dir := t.TempDir()
+	f1 := filepath.Join(dir, "a.txt")
+	f2 := filepath.Join(dir, "b.txt")
+	if err := ioutil.WriteFile(f1, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(f2, []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	byGlob, err := hashSources([]string{filepath.Join(dir, "*.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byList, err := hashSources([]string{f2, f1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byGlob != byList {
+		t.Errorf("expected the digest to be order-independent, got %s vs %s", byGlob, byList)
+	}
+
+	if err := ioutil.WriteFile(f1, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := hashSources([]string{filepath.Join(dir, "*.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == byGlob {
+		t.Error("expected the digest to change when file contents change")
+	}
+}
+
+// TestIsTaskUpToDateChecksumResolvesGeneratesGlob guards against Generates
+// globs (e.g. "dist/*.js") never being considered up to date under
+// Method: "checksum" even though the generated files exist.
+func TestIsTaskUpToDateChecksumResolvesGeneratesGlob(t *testing.T) {
+	dir := t.TempDir()
+	origTaskFilePath := TaskFilePath
+	TaskFilePath = filepath.Join(dir, "Taskfile")
+	defer func() { TaskFilePath = origTaskFilePath }()
+
+	src := filepath.Join(dir, "src.txt")
+	if err := ioutil.WriteFile(src, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	genDir := filepath.Join(dir, "dist")
+	if err := os.MkdirAll(genDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(genDir, "out.js"), []byte("built"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Task{
+		Sources:   []string{src},
+		Generates: []string{filepath.Join(genDir, "*.js")},
+		Method:    "checksum",
+	}
+
+	if isTaskUpToDateChecksum("build", task) {
+		t.Fatal("expected not up to date before a checksum has been recorded")
+	}
+	if err := writeChecksum("build", task); err != nil {
+		t.Fatal(err)
+	}
+
+	if !isTaskUpToDateChecksum("build", task) {
+		t.Error("expected up to date once the checksum matches and the Generates glob resolves to existing files")
+	}
+}
@@ -0,0 +1,128 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Executor runs a single command in a working directory and returns its
+// captured stdout so callers (e.g. Task.Set) can use it as a variable
+// value. Implementations should respect ctx cancellation/timeout and
+// stream the command's output live (long builds should show output as
+// it's produced, not only once they exit) while still serializing writes
+// so commands from concurrently running tasks (see the scheduler's "-p N"
+// pool) can't tear each other's output mid-write.
+type Executor interface {
+	Run(ctx context.Context, cmd, dir string, env []string) (string, error)
+}
+
+// execOutputMu serializes writes to the process's real stdout/stderr, so
+// two commands streaming output concurrently can't interleave mid-write.
+var execOutputMu sync.Mutex
+
+// syncWriter forwards writes to w one at a time, guarded by mu, so
+// concurrent writers (e.g. two tasks' commands streaming live) can't tear
+// each other's output.
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (s syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// shellExecutor runs commands through a shell binary, e.g. "sh -c <cmd>".
+type shellExecutor struct {
+	path string
+	arg  string
+}
+
+func (e shellExecutor) Run(ctx context.Context, c, dir string, env []string) (string, error) {
+	cmd := exec.CommandContext(ctx, e.path, e.arg, c)
+	return runExecCmd(cmd, dir, env)
+}
+
+// dockerExecutor runs commands inside a throwaway container via
+// "docker run --rm <image> sh -c <cmd>".
+type dockerExecutor struct {
+	image string
+}
+
+func (e dockerExecutor) Run(ctx context.Context, c, dir string, env []string) (string, error) {
+	args := []string{"run", "--rm"}
+	if dir != "" {
+		args = append(args, "-v", dir+":"+dir, "-w", dir)
+	}
+	for _, kv := range env {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, e.image, "sh", "-c", c)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	return runExecCmd(cmd, "", nil)
+}
+
+// runExecCmd wires up cmd's working directory and environment, streams its
+// stdout/stderr live to this process's as they're produced (through a
+// mutex-guarded writer so concurrently running commands can't tear each
+// other's output), and mirrors stdout into a buffer so the caller can
+// capture it (e.g. for Task.Set).
+func runExecCmd(cmd *exec.Cmd, dir string, env []string) (string, error) {
+	if dir != "" {
+		cmd.Dir = dir
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdout, syncWriter{mu: &execOutputMu, w: os.Stdout})
+	cmd.Stderr = syncWriter{mu: &execOutputMu, w: os.Stderr}
+
+	err := cmd.Run()
+	return stdout.String(), err
+}
+
+// executors holds the built-in Executor implementations, keyed by the
+// name a Task.Shell may reference.
+var executors = map[string]Executor{
+	"sh":      shellExecutor{path: "sh", arg: "-c"},
+	"bash":    shellExecutor{path: "bash", arg: "-c"},
+	"cmd":     shellExecutor{path: "cmd", arg: "/C"},
+	"cmd.exe": shellExecutor{path: "cmd.exe", arg: "/C"},
+	"pwsh":    shellExecutor{path: "pwsh", arg: "-Command"},
+}
+
+// RegisterExecutor makes an Executor available under name for use as a
+// Task.Shell value.
+func RegisterExecutor(name string, e Executor) {
+	executors[name] = e
+}
+
+// executorFor resolves the Executor a task should run its commands with:
+// Task.Image selects the docker executor, Task.Shell selects a registered
+// executor by name, and otherwise the process falls back to sh (or cmd on
+// systems without one).
+func executorFor(t *Task) (Executor, error) {
+	switch {
+	case t.Image != "":
+		return dockerExecutor{image: t.Image}, nil
+	case t.Shell != "":
+		e, ok := executors[t.Shell]
+		if !ok {
+			return nil, fmt.Errorf(`unknown shell "%s"`, t.Shell)
+		}
+		return e, nil
+	case ShExists:
+		return executors["sh"], nil
+	default:
+		return executors["cmd"], nil
+	}
+}
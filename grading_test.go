@@ -0,0 +1,107 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunGradingSkipsOutOfWindowGroup(t *testing.T) {
+	origTasks, origGrading := Tasks, Grading
+	defer func() { Tasks, Grading = origTasks, origGrading }()
+
+	exec := newCountingExecutor()
+	RegisterExecutor("grading-test-skip", exec)
+
+	Tasks = map[string]*Task{
+		"build": {Shell: "grading-test-skip", Cmds: []string{"build-cmd"}},
+	}
+
+	past := time.Now().Add(-48 * time.Hour)
+	Grading = map[string]*GradingGroup{
+		"expired": {
+			Start:    past.Format(gradingTimeLayout),
+			Deadline: past.Add(time.Hour).Format(gradingTimeLayout),
+			Tasks:    map[string]int{"build": 10},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := RunGrading(context.Background(), &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var report gradeReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report JSON: %v", err)
+	}
+	if len(report.Groups) != 1 || !report.Groups[0].Skipped {
+		t.Fatalf("expected expired group to be skipped, got %+v", report.Groups)
+	}
+	if exec.count["build-cmd"] != 0 {
+		t.Errorf("expected build not to run for a skipped group, ran %d times", exec.count["build-cmd"])
+	}
+}
+
+// TestRunGradingBypassesUpToDateChecks guards against a grader awarding
+// full score to a task whose Cmds never actually ran because a stale
+// Generates file made it look up to date.
+func TestRunGradingBypassesUpToDateChecks(t *testing.T) {
+	origTasks, origGrading, origForce := Tasks, Grading, Force
+	defer func() { Tasks, Grading, Force = origTasks, origGrading, origForce }()
+	Force = false
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	gen := filepath.Join(dir, "out.txt")
+	if err := ioutil.WriteFile(src, []byte("src"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := ioutil.WriteFile(gen, []byte("out"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := newCountingExecutor()
+	RegisterExecutor("grading-test-bypass", exec)
+
+	task := &Task{
+		Shell:     "grading-test-bypass",
+		Cmds:      []string{"build-cmd"},
+		Sources:   []string{src},
+		Generates: []string{gen},
+	}
+	if !isTaskUpToDate("build", task) {
+		t.Fatal("test setup invalid: expected task to look up to date via timestamps")
+	}
+
+	Tasks = map[string]*Task{"build": task}
+	Grading = map[string]*GradingGroup{
+		"g1": {
+			Start:    time.Now().Add(-time.Hour).Format(gradingTimeLayout),
+			Deadline: time.Now().Add(time.Hour).Format(gradingTimeLayout),
+			Tasks:    map[string]int{"build": 10},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := RunGrading(context.Background(), &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exec.count["build-cmd"] != 1 {
+		t.Errorf("expected grading to force-run an up-to-date task, ran %d times", exec.count["build-cmd"])
+	}
+
+	var report gradeReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report JSON: %v", err)
+	}
+	if report.Earned != 10 {
+		t.Errorf("expected full score for a passing command, got %d", report.Earned)
+	}
+}
@@ -0,0 +1,48 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Macro is a reusable command wrapper. A task command whose first word
+// names a macro is expanded into prefix + args + suffix, where args are
+// the remaining words of the original command.
+type Macro struct {
+	Prefix []string
+	Suffix []string
+}
+
+// expandMacros rewrites cmd if its first word names a macro, recursively
+// expanding as long as the result still starts with a macro name. Cyclic
+// macro references (a macro that, directly or indirectly, expands into
+// itself) are reported as an error instead of recursing forever.
+func expandMacros(cmd string) (string, error) {
+	return expandMacrosTrail(cmd, nil)
+}
+
+func expandMacrosTrail(cmd string, trail []string) (string, error) {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return cmd, nil
+	}
+
+	name := fields[0]
+	m, ok := Macros[name]
+	if !ok {
+		return cmd, nil
+	}
+	for _, seen := range trail {
+		if seen == name {
+			return "", fmt.Errorf("cyclic macro expansion detected: %s", strings.Join(append(trail, name), " -> "))
+		}
+	}
+
+	args := fields[1:]
+	expanded := make([]string, 0, len(m.Prefix)+len(args)+len(m.Suffix))
+	expanded = append(expanded, m.Prefix...)
+	expanded = append(expanded, args...)
+	expanded = append(expanded, m.Suffix...)
+
+	return expandMacrosTrail(strings.Join(expanded, " "), append(trail, name))
+}
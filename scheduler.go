@@ -0,0 +1,197 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduler runs a set of tasks while respecting Task.Deps, executing
+// independent tasks concurrently up to a fixed worker limit. Each task
+// runs at most once per scheduler even if several dependents request it,
+// fanning the remaining dependents in on the same execution.
+type scheduler struct {
+	sem chan struct{}
+
+	mu     sync.Mutex
+	states map[string]*taskState
+}
+
+// taskState tracks the outcome of a single task's execution so that
+// concurrent dependents can fan in on it via done instead of re-running it.
+type taskState struct {
+	done chan struct{}
+	err  error
+}
+
+// newScheduler creates a scheduler that runs at most concurrency tasks at
+// once. A concurrency below 1 is treated as 1 (strictly serial).
+func newScheduler(concurrency int) *scheduler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &scheduler{
+		sem:    make(chan struct{}, concurrency),
+		states: make(map[string]*taskState),
+	}
+}
+
+// detectCycles walks the dependency graph reachable from name using graph
+// coloring (white/gray/black) and returns an error describing the cycle if
+// one is found. Unlike a simple "have we started this task" set, coloring
+// correctly allows diamonds (a task reached twice via different paths,
+// but never while it is still on the current path).
+func detectCycles(name string) error {
+	const (
+		white = iota
+		gray
+		black
+	)
+	color := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch color[name] {
+		case black:
+			return nil
+		case gray:
+			return fmt.Errorf("cyclic dependency detected: %s", strings.Join(append(path, name), " -> "))
+		}
+		color[name] = gray
+		if t, ok := Tasks[name]; ok {
+			for _, d := range t.Deps {
+				if err := visit(d, append(path, name)); err != nil {
+					return err
+				}
+			}
+		}
+		color[name] = black
+		return nil
+	}
+	return visit(name, nil)
+}
+
+// run executes name and its dependencies, returning once name has finished
+// (successfully or not). It is safe to call concurrently for different
+// names that may share dependencies. ctx cancellation (e.g. Ctrl-C) aborts
+// any commands still running.
+func (s *scheduler) run(ctx context.Context, name string) error {
+	st, existing := s.stateFor(name)
+	if existing {
+		<-st.done
+		return st.err
+	}
+	defer close(st.done)
+
+	if err := ctx.Err(); err != nil {
+		st.err = err
+		return err
+	}
+
+	t, ok := Tasks[name]
+	if !ok {
+		st.err = &taskNotFoundError{name}
+		return st.err
+	}
+
+	if isTaskUpToDate(name, t) {
+		log.Printf(`Task "%s" is up to date`, name)
+		return nil
+	}
+
+	vars, err := t.handleVariables()
+	if err != nil {
+		st.err = &taskRunError{name, err}
+		return st.err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(t.Deps))
+	for _, d := range t.Deps {
+		dep := ReplaceVariables(d, vars)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.run(ctx, dep); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		st.err = err
+		return err
+	}
+
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+
+	st.err = runTaskBody(ctx, name, t)
+	return st.err
+}
+
+func (s *scheduler) stateFor(name string) (st *taskState, existing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.states[name]; ok {
+		return st, true
+	}
+	st = &taskState{done: make(chan struct{})}
+	s.states[name] = st
+	return st, false
+}
+
+// runTaskBody runs a single task's commands in order, through the
+// Executor the task selects (see executorFor), streaming their output
+// live. If t.Timeout is set it bounds the whole run of commands.
+func runTaskBody(ctx context.Context, name string, t *Task) error {
+	if t.Timeout != "" {
+		d, err := time.ParseDuration(t.Timeout)
+		if err != nil {
+			return &taskRunError{name, err}
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	executor, err := executorFor(t)
+	if err != nil {
+		return &taskRunError{name, err}
+	}
+
+	vars, err := t.handleVariables()
+	if err != nil {
+		return &taskRunError{name, err}
+	}
+	for _, c := range t.Cmds {
+		// read in a each time, as a command could change a variable or it has been changed by a dependency
+		vars, err = t.handleVariables()
+		if err != nil {
+			return &taskRunError{name, err}
+		}
+		expanded, err := expandMacros(c)
+		if err != nil {
+			return &taskRunError{name, err}
+		}
+		output, err := executor.Run(ctx, ReplaceVariables(expanded, vars), ReplaceVariables(t.Dir, vars), nil)
+		if err != nil {
+			return &taskRunError{name, err}
+		}
+		if t.Set != "" {
+			os.Setenv(t.Set, output)
+		}
+	}
+
+	if t.Method == "checksum" {
+		if err := writeChecksum(name, t); err != nil {
+			return &taskRunError{name, err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// countingExecutor is a test Executor that records how many times each
+// command string was run, instead of spawning a real process.
+type countingExecutor struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func newCountingExecutor() *countingExecutor {
+	return &countingExecutor{count: make(map[string]int)}
+}
+
+func (e *countingExecutor) Run(ctx context.Context, cmd, dir string, env []string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.count[cmd]++
+	return "", nil
+}
+
+func TestDetectCyclesAllowsDiamond(t *testing.T) {
+	orig := Tasks
+	defer func() { Tasks = orig }()
+	Tasks = map[string]*Task{
+		"a": {Deps: []string{"b", "c"}},
+		"b": {Deps: []string{"d"}},
+		"c": {Deps: []string{"d"}},
+		"d": {},
+	}
+	if err := detectCycles("a"); err != nil {
+		t.Fatalf("expected a diamond dependency to be accepted, got: %v", err)
+	}
+}
+
+func TestDetectCyclesRejectsCycle(t *testing.T) {
+	orig := Tasks
+	defer func() { Tasks = orig }()
+	Tasks = map[string]*Task{
+		"a": {Deps: []string{"b"}},
+		"b": {Deps: []string{"a"}},
+	}
+	if err := detectCycles("a"); err == nil {
+		t.Fatal("expected a cyclic dependency to be rejected")
+	}
+}
+
+func TestSchedulerRunsSharedDepOnce(t *testing.T) {
+	orig := Tasks
+	defer func() { Tasks = orig }()
+
+	exec := newCountingExecutor()
+	RegisterExecutor("scheduler-test-fanin", exec)
+
+	Tasks = map[string]*Task{
+		"shared": {Shell: "scheduler-test-fanin", Cmds: []string{"shared-cmd"}},
+		"a":      {Shell: "scheduler-test-fanin", Cmds: []string{"a-cmd"}, Deps: []string{"shared"}},
+		"b":      {Shell: "scheduler-test-fanin", Cmds: []string{"b-cmd"}, Deps: []string{"shared"}},
+		"top":    {Shell: "scheduler-test-fanin", Cmds: []string{"top-cmd"}, Deps: []string{"a", "b"}},
+	}
+
+	sched := newScheduler(4)
+	if err := sched.run(context.Background(), "top"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if exec.count["shared-cmd"] != 1 {
+		t.Errorf("expected the shared dependency to run exactly once, ran %d times", exec.count["shared-cmd"])
+	}
+	if exec.count["top-cmd"] != 1 {
+		t.Errorf("expected top to run exactly once, ran %d times", exec.count["top-cmd"])
+	}
+}
@@ -1,13 +1,17 @@
 package task
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v2"
@@ -24,7 +28,19 @@ var (
 	// Tasks constains the tasks parsed from Taskfile
 	Tasks = make(map[string]*Task)
 
-	runTasks = make(map[string]bool)
+	// Macros constains the macros parsed from Taskfile
+	Macros = make(map[string]*Macro)
+
+	// Grading constains the grading groups parsed from Taskfile
+	Grading = make(map[string]*GradingGroup)
+
+	// defaultConcurrency is how many tasks RunTask runs in parallel when
+	// called outside of Run (e.g. from tests or other packages).
+	defaultConcurrency = 1
+
+	// Force, when true, makes isTaskUpToDate always report false so every
+	// task runs regardless of its Method's timestamp/checksum check.
+	Force bool
 )
 
 func init() {
@@ -34,6 +50,7 @@ func init() {
 		return
 	}
 	ShExists = true
+	executors["sh"] = shellExecutor{path: ShPath, arg: "-c"}
 }
 
 // Task represents a task
@@ -45,6 +62,21 @@ type Task struct {
 	Dir       string
 	Variables map[string]string
 	Set       string
+	// Shell selects the Executor used to run Cmds (see RegisterExecutor).
+	// Defaults to "sh" (or "cmd" where sh isn't available) when empty and
+	// Image is unset.
+	Shell string
+	// Image, when set, runs Cmds inside a "docker run" of this image
+	// instead of Shell.
+	Image string
+	// Timeout, if set, is a duration string (e.g. "30s") bounding how long
+	// the task's commands may run in total.
+	Timeout string
+	// Method selects how isTaskUpToDate decides whether Cmds can be
+	// skipped: "timestamp" (the default) compares mtimes of Sources vs.
+	// Generates, "checksum" hashes the contents of Sources instead (see
+	// checksum.go).
+	Method string
 }
 
 type taskNotFoundError struct {
@@ -68,71 +100,78 @@ func (err *taskRunError) Error() string {
 func Run() {
 	log.SetFlags(0)
 
-	args := os.Args[1:]
+	p := flag.Int("p", 1, "number of tasks to run in parallel")
+	force := flag.Bool("force", false, "run tasks even if they're up to date")
+	flag.Parse()
+	Force = *force
+	args := flag.Args()
 	if len(args) == 0 {
 		log.Fatal("No argument given")
 	}
 
 	var err error
-	Tasks, err = readTaskfile()
+	Tasks, Macros, Grading, err = readTaskfile()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for _, a := range args {
-		if err = RunTask(a); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if len(args) == 1 && args[0] == "grade" {
+		if err := RunGrading(ctx, os.Stdout); err != nil {
 			log.Fatal(err)
 		}
+		return
 	}
-}
 
-// RunTask runs a task by its name
-func RunTask(name string) error {
-	if _, found := runTasks[name]; found {
-		return &taskRunError{taskName: name, err: fmt.Errorf("Cyclic dependency detected")}
+	for _, a := range args {
+		if err := detectCycles(a); err != nil {
+			log.Fatal(err)
+		}
 	}
-	runTasks[name] = true
 
-	t, ok := Tasks[name]
-	if !ok {
-		return &taskNotFoundError{name}
+	sched := newScheduler(*p)
+	for _, a := range args {
+		if err = sched.run(ctx, a); err != nil {
+			log.Fatal(err)
+		}
 	}
+}
 
-	if isTaskUpToDate(t) {
-		log.Printf(`Task "%s" is up to date`, name)
-		return nil
+// RunTask runs a task, and its dependencies, by name, respecting ctx
+// cancellation (e.g. Ctrl-C) and any per-task Timeout. It schedules them
+// as Run does, but with a pool of defaultConcurrency (1) worker, so their
+// commands run one task at a time (Run's "-p" flag sets the pool size used
+// by the CLI); callers that need a specific pool size should use a
+// scheduler directly.
+func RunTask(ctx context.Context, name string) error {
+	if err := detectCycles(name); err != nil {
+		return err
 	}
-	vars, err := t.handleVariables()
-	if err != nil {
-		return &taskRunError{name, err}
+	return newScheduler(defaultConcurrency).run(ctx, name)
+}
+
+// isTaskUpToDate reports whether t's commands can be skipped, per its
+// Method (see checksum.go for "checksum"; this is the "timestamp" default).
+func isTaskUpToDate(name string, t *Task) bool {
+	if Force {
+		return false
 	}
-	for _, d := range t.Deps {
-		if err := RunTask(ReplaceVariables(d, vars)); err != nil {
-			return err
-		}
+	if t.Method == "checksum" {
+		return isTaskUpToDateChecksum(name, t)
 	}
-	for _, c := range t.Cmds {
-		// read in a each time, as a command could change a variable or it has been changed by a dependency
-		vars, err = t.handleVariables()
-		if err != nil {
-			return &taskRunError{name, err}
-		}
-		var (
-			output string
-			err    error
-		)
-		if output, err = runCommand(ReplaceVariables(c, vars), ReplaceVariables(t.Dir, vars)); err != nil {
-			return &taskRunError{name, err}
-		}
-		fmt.Println(output)
-		if t.Set != "" {
-			os.Setenv(t.Set, output)
-		}
-	}
-	return nil
-}
 
-func isTaskUpToDate(t *Task) bool {
 	if len(t.Sources) == 0 || len(t.Generates) == 0 {
 		return false
 	}
@@ -150,38 +189,105 @@ func isTaskUpToDate(t *Task) bool {
 	return generatesMinTime.After(sourcesMaxTime)
 }
 
-func runCommand(c, path string) (string, error) {
+// taskfileContents mirrors the on-disk layout of a Taskfile: a "tasks:"
+// section holding the tasks themselves, and a "macros:" section (see
+// Macro) holding reusable command wrappers tasks can invoke.
+type taskfileContents struct {
+	Tasks   map[string]*Task         `yaml:"tasks" json:"tasks" toml:"tasks"`
+	Macros  map[string]*Macro        `yaml:"macros" json:"macros" toml:"macros"`
+	Grading map[string]*GradingGroup `yaml:"grading" json:"grading" toml:"grading"`
+}
+
+// unmarshalTaskfile decodes b (in the given format: "yaml", "json" or
+// "toml") into v.
+func unmarshalTaskfile(format string, b []byte, v interface{}) error {
+	switch format {
+	case "yaml":
+		return yaml.Unmarshal(b, v)
+	case "json":
+		return json.Unmarshal(b, v)
+	case "toml":
+		return toml.Unmarshal(b, v)
+	}
+	return fmt.Errorf("unknown taskfile format %q", format)
+}
+
+func readTaskfile() (tasks map[string]*Task, macros map[string]*Macro, grading map[string]*GradingGroup, err error) {
 	var (
-		cmd *exec.Cmd
-		b   []byte
-		err error
+		b      []byte
+		format string
 	)
-	if ShExists {
-		cmd = exec.Command(ShPath, "-c", c)
+	if b, err = ioutil.ReadFile(TaskFilePath + ".yml"); err == nil {
+		format = "yaml"
+	} else if b, err = ioutil.ReadFile(TaskFilePath + ".json"); err == nil {
+		format = "json"
+	} else if b, err = ioutil.ReadFile(TaskFilePath + ".toml"); err == nil {
+		format = "toml"
 	} else {
-		cmd = exec.Command("cmd", "/C", c)
+		return nil, nil, nil, ErrNoTaskFile
 	}
-	if path != "" {
-		cmd.Dir = path
+
+	// Peek at the document's top-level keys to tell a "tasks:"/"macros:"/
+	// "grading:" Taskfile apart from the older format, where the document
+	// root is itself a flat map of task name to Task. Presence of a
+	// "tasks"/"macros"/"grading" key alone isn't enough: a flat Taskfile
+	// could just as well have a task literally named "tasks". Only treat
+	// the document as the structured schema when that key's value isn't
+	// itself shaped like a Task (i.e. it doesn't have Task fields like
+	// "cmds"/"deps" directly on it).
+	raw := make(map[string]interface{})
+	if err = unmarshalTaskfile(format, b, &raw); err != nil {
+		return nil, nil, nil, err
 	}
-	cmd.Stderr = os.Stderr
-	if b, err = cmd.Output(); err != nil {
-		return "", err
+	nested := false
+	for _, key := range []string{"tasks", "macros", "grading"} {
+		if v, ok := raw[key]; ok && !looksLikeTask(v) {
+			nested = true
+		}
 	}
-	return string(b), nil
-}
 
-func readTaskfile() (tasks map[string]*Task, err error) {
-	if b, err := ioutil.ReadFile(TaskFilePath + ".yml"); err == nil {
-		return tasks, yaml.Unmarshal(b, &tasks)
+	if nested {
+		var c taskfileContents
+		if err = unmarshalTaskfile(format, b, &c); err != nil {
+			return nil, nil, nil, err
+		}
+		return c.Tasks, c.Macros, c.Grading, nil
 	}
-	if b, err := ioutil.ReadFile(TaskFilePath + ".json"); err == nil {
-		return tasks, json.Unmarshal(b, &tasks)
+
+	var flat map[string]*Task
+	if err = unmarshalTaskfile(format, b, &flat); err != nil {
+		return nil, nil, nil, err
 	}
-	if b, err := ioutil.ReadFile(TaskFilePath + ".toml"); err == nil {
-		return tasks, toml.Unmarshal(b, &tasks)
+	return flat, nil, nil, nil
+}
+
+// taskFields are Task's own field names (lowercased), used by
+// looksLikeTask to recognize a map shaped like a single Task.
+var taskFields = map[string]bool{
+	"cmds": true, "deps": true, "sources": true, "generates": true,
+	"dir": true, "variables": true, "set": true, "shell": true,
+	"image": true, "timeout": true, "method": true,
+}
+
+// looksLikeTask reports whether v (a decoded YAML/JSON/TOML value) is a
+// map with at least one key matching a Task field, i.e. v itself is a
+// single Task rather than a map of task name to Task.
+func looksLikeTask(v interface{}) bool {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		for k := range m {
+			if taskFields[strings.ToLower(k)] {
+				return true
+			}
+		}
+	case map[interface{}]interface{}:
+		for k := range m {
+			if ks, ok := k.(string); ok && taskFields[strings.ToLower(ks)] {
+				return true
+			}
+		}
 	}
-	return nil, ErrNoTaskFile
+	return false
 }
 
 // ErrNoTaskFile is returns when the program can not find a proper TaskFile
@@ -0,0 +1,57 @@
+package task
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func withTempTaskfile(t *testing.T, content string) {
+	t.Helper()
+	dir := t.TempDir()
+	origPath := TaskFilePath
+	TaskFilePath = filepath.Join(dir, "Taskfile")
+	t.Cleanup(func() { TaskFilePath = origPath })
+
+	if err := ioutil.WriteFile(TaskFilePath+".yml", []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReadTaskfileFlatWithTaskNamedTasks guards against the "tasks:"/
+// "macros:"/"grading:" detection mistaking a flat Taskfile whose single
+// task happens to be named "tasks" for the nested schema.
+func TestReadTaskfileFlatWithTaskNamedTasks(t *testing.T) {
+	withTempTaskfile(t, "tasks:\n  cmds:\n    - echo hi\n")
+
+	tasks, macros, grading, err := readTaskfile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(macros) != 0 || len(grading) != 0 {
+		t.Fatalf("expected no macros/grading, got %d/%d", len(macros), len(grading))
+	}
+	task, ok := tasks["tasks"]
+	if !ok {
+		t.Fatalf(`expected a task literally named "tasks", got %v`, tasks)
+	}
+	if len(task.Cmds) != 1 || task.Cmds[0] != "echo hi" {
+		t.Errorf("unexpected task contents: %+v", task)
+	}
+}
+
+func TestReadTaskfileNestedSchema(t *testing.T) {
+	withTempTaskfile(t, "tasks:\n  build:\n    cmds:\n      - echo hi\n"+
+		"macros:\n  wrap:\n    prefix: [\"echo\"]\n")
+
+	tasks, macros, _, err := readTaskfile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := tasks["build"]; !ok {
+		t.Fatalf(`expected task "build", got %v`, tasks)
+	}
+	if _, ok := macros["wrap"]; !ok {
+		t.Fatalf(`expected macro "wrap", got %v`, macros)
+	}
+}